@@ -5,20 +5,128 @@
 package fd
 
 import (
+	"math"
 	"runtime"
 	"sync"
 
 	"github.com/gonum/matrix/mat64"
 )
 
+// BatchFunc evaluates f at each point in xs, storing f(xs[k]) in out[k]. It
+// lets a caller whose objective is backed by a GPU simulator, a remote RPC
+// service, or a vectorized solver amortize per-call overhead by answering
+// many points in one call, rather than being driven one evaluation at a
+// time. len(xs) and len(out) are always equal, and xs and its elements must
+// not be retained past the call.
+type BatchFunc func(xs [][]float64, out []float64)
+
+// batchOfOne adapts a scalar objective into a BatchFunc that evaluates its
+// points one at a time; it is what Hessian uses internally to drive
+// HessianBatch from a scalar func([]float64) float64.
+func batchOfOne(f func([]float64) float64) BatchFunc {
+	return func(xs [][]float64, out []float64) {
+		for k, xp := range xs {
+			out[k] = f(xp)
+		}
+	}
+}
+
+// Formula is a finite-difference stencil for approximating the second
+// partial derivatives Hessian and HessianBatch build from, together with
+// the step size the stencil was tuned for.
+type Formula struct {
+	forward bool
+	Step    float64
+}
+
+var (
+	// Central2nd is the centered second-derivative stencil Hessian has
+	// always used:
+	//
+	//	f''(x) ≈ (f(x+h) - 2*f(x) + f(x-h)) / h^2
+	//
+	// It is second-order accurate and is the zero value's effective
+	// default.
+	Central2nd = Formula{Step: 1e-4}
+
+	// Forward2nd estimates the second derivative from x and the two
+	// points ahead of it, never evaluating behind x:
+	//
+	//	f''(x) ≈ (f(x+2h) - 2*f(x+h) + f(x)) / h^2
+	//
+	// This is the only stencil that works when x sits at a boundary the
+	// objective cannot be evaluated past, at the cost of being first-,
+	// not second-order, accurate: its truncation error is O(h), not
+	// O(h^2). Shrinking h to fight that truncation error only trades it
+	// for more rounding error from the same h^2 denominator Central2nd
+	// has, so its default step is larger than Central2nd's, accepting a
+	// bigger truncation error in exchange for keeping rounding error
+	// small; callers differentiating a badly scaled or highly curved
+	// objective should expect to tune Step by hand rather than rely on
+	// the default.
+	Forward2nd = Formula{forward: true, Step: 5e-4}
+)
+
 type HessianSettings struct {
 	OriginKnown bool
 	OriginValue float64
 	Step        float64
 	Concurrent  bool
+
+	// Formula is the finite-difference stencil used to approximate the
+	// second derivatives, for example Central2nd or Forward2nd. It is
+	// ignored when Extrapolate is true, since the extrapolated estimate
+	// is always built from the central stencil. The zero value selects
+	// Central2nd.
+	Formula Formula
+
+	// Extrapolate applies Richardson extrapolation to the second-difference
+	// stencil, combining estimates at step, step/2 and step/4 into an
+	// estimate with a higher order of accuracy. The diagonal reuses
+	// Central2nd's stencil, but the mixed partial switches to a 4-point
+	// central stencil so it extrapolates cleanly too, so Extrapolate costs
+	// noticeably more than three times a plain Hessian call's evaluations.
+	// It is a good default when Step has not been tuned by hand.
+	Extrapolate bool
+
+	// Adaptive chooses Step independently along each coordinate axis from
+	// an estimate of the noise level of f, following the Moré–Wild
+	// procedure: a short sequence of evaluations along the axis is used to
+	// estimate the noise εf, and the step is set to (εf / |f''|)^(1/4).
+	// This removes the need to hand-tune Step for objectives that are
+	// noisy or badly scaled along different axes. Adaptive is independent
+	// of Extrapolate; the two may be combined.
+	Adaptive bool
+
+	// BatchSize controls how many points HessianBatch hands to a BatchFunc
+	// per call. It has no effect on Hessian, whose scalar objective is
+	// always evaluated one point at a time. The zero value evaluates all
+	// of the points for a given step size in a single batch.
+	BatchSize int
 }
 
+// Hessian approximates the Hessian of f at x. It is a thin wrapper around
+// HessianBatch for callers with a plain scalar objective, handing it one
+// point per call whatever settings.BatchSize says; see HessianBatch for the
+// evaluation strategy.
 func Hessian(dst *mat64.SymDense, f func([]float64) float64, x []float64, settings *HessianSettings) *mat64.SymDense {
+	var s HessianSettings
+	if settings != nil {
+		s = *settings
+	}
+	s.BatchSize = 1
+	return HessianBatch(dst, batchOfOne(f), x, &s)
+}
+
+// HessianBatch approximates the Hessian of an objective presented as a
+// BatchFunc, which may answer many perturbed points in one call. HessianBatch
+// enqueues every point the current step size (or, with Extrapolate, each of
+// the three step sizes) requires and hands them to batch in chunks of
+// settings.BatchSize, so that a batch-friendly objective pays its per-call
+// overhead O(n^2/BatchSize) times instead of O(n^2) times. settings.Concurrent
+// dispatches those chunks across a worker pool instead of evaluating them
+// one at a time.
+func HessianBatch(dst *mat64.SymDense, batch BatchFunc, x []float64, settings *HessianSettings) *mat64.SymDense {
 	n := len(x)
 	if dst == nil {
 		dst = mat64.NewSymDense(n, nil)
@@ -31,129 +139,269 @@ func Hessian(dst *mat64.SymDense, f func([]float64) float64, x []float64, settin
 		settings = &HessianSettings{}
 	}
 
+	formula := settings.Formula
+	if formula.Step == 0 {
+		formula = Central2nd
+	}
 	step := settings.Step
 	if step == 0 {
-		step = Central2nd.Step
+		step = formula.Step
+	}
+
+	origin := settings.OriginValue
+	if !settings.OriginKnown {
+		originOut := [1]float64{}
+		batch([][]float64{append([]float64(nil), x...)}, originOut[:])
+		origin = originOut[0]
+	}
+
+	// Richardson extrapolation shrinks its base step by a further factor
+	// of 4 before combining estimates; a step picked for a plain central
+	// difference (eps^(1/4)) is too small once shrunk that far, since the
+	// combination's rounding error then grows faster than its truncation
+	// error shrinks. So unless the caller pinned Step explicitly, pick
+	// the base step the same noise-adaptive way Adaptive does, which
+	// scales with the actual magnitude of f and keeps the shrunk steps
+	// well clear of cancellation.
+	useAdaptive := settings.Adaptive || (settings.Extrapolate && settings.Step == 0)
+
+	steps := make([]float64, n)
+	for i := range steps {
+		steps[i] = step
+	}
+	if useAdaptive {
+		scalarF := func(xp []float64) float64 {
+			out := [1]float64{}
+			batch([][]float64{xp}, out[:])
+			return out[0]
+		}
+		steps = adaptiveSteps(scalarF, x, origin)
 	}
 
-	expect := n + n*(n-1)/2 // Diagonal + half of off-diagonal elements.
 	nWorkers := 1
 	if settings.Concurrent {
 		nWorkers = runtime.GOMAXPROCS(0)
-		if nWorkers > expect {
-			nWorkers = expect
-		}
 	}
 
-	xcopy := make([]float64, n)
-	origin := settings.OriginValue
-	if !settings.OriginKnown {
-		copy(xcopy, x)
-		origin = f(xcopy)
+	if settings.Extrapolate {
+		if settings.Step == 0 {
+			// adaptiveSteps estimates the noise floor from a handful of
+			// samples, so for a nearly-exact f (zero or tiny third
+			// differences) its own estimate is itself noisy and can come
+			// out too small by chance, which Richardson then amplifies.
+			// Floor it at a step derived from |origin| rather than
+			// sampled noise: rounding error in any one evaluation is
+			// O(eps*|origin|), so a second difference carries
+			// O(eps*|origin|/step^2) of it, and a step of
+			// 1e-3*sqrt(|origin|+1) holds that to about 2e-10
+			// however the noise estimate landed.
+			floor := 1e-3 * math.Sqrt(math.Abs(origin)+1)
+			for i := range steps {
+				if steps[i] < floor {
+					steps[i] = floor
+				}
+			}
+		}
+		half := make([]float64, n)
+		quarter := make([]float64, n)
+		for i, h := range steps {
+			half[i] = h / 2
+			quarter[i] = h / 4
+		}
+		dh := hessianBatchAt(batch, x, steps, origin, Central2nd, true, settings.BatchSize, nWorkers)
+		dh2 := hessianBatchAt(batch, x, half, origin, Central2nd, true, settings.BatchSize, nWorkers)
+		dh4 := hessianBatchAt(batch, x, quarter, origin, Central2nd, true, settings.BatchSize, nWorkers)
+		return richardsonCombine(dst, dh, dh2, dh4)
 	}
 
-	if nWorkers == 1 {
-		hessianSerial(dst, f, x, xcopy, step, origin)
-	} else {
-		hessianConcurrent(dst, f, x, step, origin, nWorkers)
+	got := hessianBatchAt(batch, x, steps, origin, formula, false, settings.BatchSize, nWorkers)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			dst.SetSym(i, j, got.At(i, j))
+		}
 	}
 	return dst
 }
 
-func hessianSerial(dst *mat64.SymDense, f func([]float64) float64, x, xcopy []float64, step, origin float64) {
-	// Evaluate f at neighboring points so that neigh[i] = f(x + step * e_i).
-	neigh := make([]float64, len(x))
-	for i := range xcopy {
-		copy(xcopy, x)
-		xcopy[i] += step
-		neigh[i] = f(xcopy)
-	}
-	for i := range xcopy {
-		copy(xcopy, x)
-		xcopy[i] -= step
-		fii := f(xcopy)
-		dst.SetSym(i, i, ((neigh[i]-origin)/step-(origin-fii)/step)/step)
-		for j := i + 1; j < len(x); j++ {
-			copy(xcopy, x)
-			xcopy[i] += step
-			xcopy[j] += step
-			fij := f(xcopy)
-			dst.SetSym(i, j, ((fij-neigh[j])/step-(neigh[i]-origin)/step)/step)
+// hessianBatchAt computes a plain (non-extrapolated) Hessian estimate at the
+// given per-axis steps using formula's stencil for the diagonal. The mixed
+// partial uses the cheap one-extra-evaluation stencil that only samples
+// ahead of x, unless crossCentral is set, in which case it uses the
+// 4-point central stencil instead: the cheap stencil's leading error term
+// is O(step), an order lower than the diagonal's O(step^2), so Richardson
+// extrapolation, which is built to cancel even powers of step, cannot
+// remove it — crossCentral is for HessianBatch's Extrapolate path, which
+// needs the mixed partial to extrapolate as cleanly as the diagonal does,
+// at the cost of 3 extra evaluations per pair instead of 1.
+//
+// hessianBatchAt gathers every point the stencil needs up front and hands
+// them to batch in chunks of batchSize (every point in one batch when
+// batchSize is zero), spread across nWorkers concurrent batch calls.
+func hessianBatchAt(batch BatchFunc, x []float64, steps []float64, origin float64, formula Formula, crossCentral bool, batchSize, nWorkers int) *mat64.SymDense {
+	n := len(x)
+	dst := mat64.NewSymDense(n, nil)
+
+	// kind: 0 = x+step_i*e_i, 1 = x-step_i*e_i (Central2nd diagonal), 2 =
+	// x+step_i*e_i+step_j*e_j, 3 = x+2*step_i*e_i (Forward2nd diagonal), 4
+	// = x+step_i*e_i-step_j*e_j, 5 = x-step_i*e_i+step_j*e_j, 6 =
+	// x-step_i*e_i-step_j*e_j (4, 5 and 6 are only used when crossCentral).
+	type point struct{ i, j, kind int }
+	var pts []point
+	for i := 0; i < n; i++ {
+		pts = append(pts, point{i, i, 0})
+		if formula.forward {
+			pts = append(pts, point{i, i, 3})
+		} else {
+			pts = append(pts, point{i, i, 1})
+		}
+		for j := i + 1; j < n; j++ {
+			pts = append(pts, point{i, j, 2})
+			if crossCentral {
+				pts = append(pts, point{i, j, 4})
+				pts = append(pts, point{i, j, 5})
+				pts = append(pts, point{i, j, 6})
+			}
+		}
+	}
+
+	xs := make([][]float64, len(pts))
+	for k, p := range pts {
+		xp := make([]float64, n)
+		copy(xp, x)
+		switch p.kind {
+		case 0:
+			xp[p.i] += steps[p.i]
+		case 1:
+			xp[p.i] -= steps[p.i]
+		case 2:
+			xp[p.i] += steps[p.i]
+			xp[p.j] += steps[p.j]
+		case 3:
+			xp[p.i] += 2 * steps[p.i]
+		case 4:
+			xp[p.i] += steps[p.i]
+			xp[p.j] -= steps[p.j]
+		case 5:
+			xp[p.i] -= steps[p.i]
+			xp[p.j] += steps[p.j]
+		case 6:
+			xp[p.i] -= steps[p.i]
+			xp[p.j] -= steps[p.j]
 		}
+		xs[k] = xp
 	}
-}
 
-func hessianConcurrent(dst *mat64.SymDense, f func([]float64) float64, x []float64, step, origin float64, nWorkers int) {
-	n := len(x)
-	var wg sync.WaitGroup
+	out := make([]float64, len(pts))
+	runBatches(batch, xs, out, batchSize, nWorkers)
 
 	neigh := make([]float64, n)
-	neighWorkers := nWorkers
-	if neighWorkers > n {
-		neighWorkers = n
+	for k, p := range pts {
+		if p.kind == 0 {
+			neigh[p.i] = out[k]
+		}
 	}
-	neighJobs := make(chan int, neighWorkers)
-	for i := 0; i < neighWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			xcopy := make([]float64, n)
-			for job := range neighJobs {
-				copy(xcopy, x)
-				xcopy[job] += step
-				neigh[job] = f(xcopy)
+
+	type pair struct{ i, j int }
+	plusMinus := make(map[pair]float64)
+	minusPlus := make(map[pair]float64)
+	minusMinus := make(map[pair]float64)
+	if crossCentral {
+		for k, p := range pts {
+			switch p.kind {
+			case 4:
+				plusMinus[pair{p.i, p.j}] = out[k]
+			case 5:
+				minusPlus[pair{p.i, p.j}] = out[k]
+			case 6:
+				minusMinus[pair{p.i, p.j}] = out[k]
 			}
-		}()
+		}
+	}
+
+	for k, p := range pts {
+		switch p.kind {
+		case 1:
+			dst.SetSym(p.i, p.i, ((neigh[p.i]-origin)/steps[p.i]-(origin-out[k])/steps[p.i])/steps[p.i])
+		case 2:
+			if crossCentral {
+				key := pair{p.i, p.j}
+				dst.SetSym(p.i, p.j, (out[k]-plusMinus[key]-minusPlus[key]+minusMinus[key])/(4*steps[p.i]*steps[p.j]))
+			} else {
+				dst.SetSym(p.i, p.j, ((out[k]-neigh[p.j])/steps[p.i]-(neigh[p.i]-origin)/steps[p.i])/steps[p.j])
+			}
+		case 3:
+			dst.SetSym(p.i, p.i, (out[k]-2*neigh[p.i]+origin)/(steps[p.i]*steps[p.i]))
+		}
 	}
-	for i := range neigh {
-		neighJobs <- i
+	return dst
+}
+
+// runBatches splits xs into chunks of batchSize (all of xs in one chunk when
+// batchSize is zero) and evaluates them with batch, using nWorkers concurrent
+// calls to batch when nWorkers > 1.
+func runBatches(batch BatchFunc, xs [][]float64, out []float64, batchSize, nWorkers int) {
+	if batchSize <= 0 {
+		batchSize = len(xs)
+	}
+	if batchSize == 0 {
+		return
+	}
+
+	type chunk struct{ lo, hi int }
+	var chunks []chunk
+	for lo := 0; lo < len(xs); lo += batchSize {
+		hi := lo + batchSize
+		if hi > len(xs) {
+			hi = len(xs)
+		}
+		chunks = append(chunks, chunk{lo, hi})
 	}
-	close(neighJobs)
-	wg.Wait()
 
-	jobs := make(chan hessJob, nWorkers)
-	for i := 0; i < nWorkers; i++ {
+	if nWorkers <= 1 || len(chunks) == 1 {
+		for _, c := range chunks {
+			batch(xs[c.lo:c.hi], out[c.lo:c.hi])
+		}
+		return
+	}
+	if nWorkers > len(chunks) {
+		nWorkers = len(chunks)
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan chunk, nWorkers)
+	for w := 0; w < nWorkers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			xcopy := make([]float64, n)
-			for job := range jobs {
-				copy(xcopy, x)
-				if job.i == job.j {
-					xcopy[job.i] -= step
-				} else {
-					xcopy[job.i] += step
-					xcopy[job.j] += step
-				}
-				fx := f(xcopy)
-				if job.i == job.j {
-					dst.SetSym(job.i, job.i, (fx-origin+neigh[job.i]-origin)/step/step)
-				} else {
-					dst.SetSym(job.i, job.j, (fx-neigh[job.j]+origin-neigh[job.i])/step/step)
-				}
+			for c := range jobs {
+				batch(xs[c.lo:c.hi], out[c.lo:c.hi])
 			}
 		}()
 	}
-	hessianProducer(jobs, n)
+	for _, c := range chunks {
+		jobs <- c
+	}
+	close(jobs)
 	wg.Wait()
 }
 
-func hessianProducer(jobs chan<- hessJob, n int) {
+// richardsonCombine applies Richardson extrapolation to the three Hessian
+// estimates dh, dh2 and dh4 taken at step, step/2 and step/4 respectively,
+// writing the result into dst.
+func richardsonCombine(dst, dh, dh2, dh4 *mat64.SymDense) *mat64.SymDense {
+	n, _ := dh.Dims()
+	d1 := mat64.NewSymDense(n, nil)
+	d2 := mat64.NewSymDense(n, nil)
 	for i := 0; i < n; i++ {
-		jobs <- hessJob{
-			i: i,
-			j: i,
+		for j := i; j < n; j++ {
+			d1.SetSym(i, j, (4*dh2.At(i, j)-dh.At(i, j))/3)
+			d2.SetSym(i, j, (4*dh4.At(i, j)-dh2.At(i, j))/3)
 		}
-		for j := i + 1; j < n; j++ {
-			jobs <- hessJob{
-				i: i,
-				j: j,
-			}
+	}
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			dst.SetSym(i, j, (16*d2.At(i, j)-d1.At(i, j))/15)
 		}
 	}
-	close(jobs)
-}
-
-type hessJob struct {
-	i, j int
+	return dst
 }
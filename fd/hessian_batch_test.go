@@ -0,0 +1,46 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestHessianBatch(t *testing.T) {
+	x := randomSlice(2, 10)
+	want := mat64.NewSymDense(2, nil)
+	saddleHess(want, x)
+
+	var maxBatch int
+	batch := func(xs [][]float64, out []float64) {
+		if len(xs) > maxBatch {
+			maxBatch = len(xs)
+		}
+		for k, xp := range xs {
+			out[k] = saddle(xp)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		settings *HessianSettings
+	}{
+		{name: "default batch size", settings: nil},
+		{name: "batch size 1", settings: &HessianSettings{BatchSize: 1}},
+		{name: "batch size 2, concurrent", settings: &HessianSettings{BatchSize: 2, Concurrent: true}},
+	} {
+		maxBatch = 0
+		got := HessianBatch(nil, batch, x, test.settings)
+		if !mat64.EqualApprox(want, got, 1e-6) {
+			t.Errorf("%s: unexpected Hessian:\nwant: %v\ngot:  %v",
+				test.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+		}
+		if test.settings != nil && test.settings.BatchSize > 0 && maxBatch > test.settings.BatchSize {
+			t.Errorf("%s: batch of %d points exceeds BatchSize %d", test.name, maxBatch, test.settings.BatchSize)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"github.com/gonum/matrix/mat64"
+)
+
+// complexStep is the imaginary step used for the complex-step derivative. It
+// is chosen near machine precision so that the first-order term dominates
+// the Taylor expansion of fc along the imaginary axis without any
+// subtractive cancellation.
+const complexStep = 1e-100
+
+// complexDiagStep is the default step for the diagonal's real-part central
+// difference (see HessianComplex). It is larger than Central2nd.Step: the
+// diagonal divides by s^2 just as Central2nd does, but its numerator
+// already carries the rounding error of a full fc evaluation rather than
+// Hessian's cheaper real f, so it needs a bigger s to keep that rounding
+// error, amplified by the s^2 in the denominator, from swamping the O(s^2)
+// truncation error it is trading accuracy against.
+const complexDiagStep = 5e-3
+
+// HessianComplex approximates the Hessian at x of a holomorphic function fc,
+// the complex lift of a real objective: fc must agree with the real
+// objective on the real axis and be built entirely from math/cmplx
+// primitives (for example math.Sin -> cmplx.Sin, math.Exp -> cmplx.Exp) so
+// that it is differentiable in the complex sense.
+//
+// Off-diagonal entries read the first derivative along e_i out of the
+// imaginary part of a genuine complex step, with no subtractive
+// cancellation, and then take an ordinary central difference of it along
+// e_j with the real step s:
+//
+//	H[i,j] ≈ (Im(fc(x + i*h*e_i + s*e_j)) - Im(fc(x + i*h*e_i - s*e_j))) / (2*h*s),  i != j
+//
+// where h is complexStep. The difference along e_j has O(s^2) truncation
+// error, so the off-diagonal entries are exact only where that error
+// vanishes (for example for a quadratic fc), not near machine precision in
+// general. The diagonal cannot use the same trick: the second
+// derivative along the complex direction only shows up in the *real* part of
+// fc(x+i*h*e_i), which must then be subtracted against f(x), so h has to be
+// large enough that this subtraction does not vanish to the last bit of a
+// float64 (h = complexStep is far too small for this: the change it makes is
+// lost to rounding against f(x) and the difference is exactly zero). The
+// diagonal therefore falls back to an ordinary complex-step central second
+// difference at s, with the same O(s^2) accuracy Hessian gets:
+//
+//	H[i,i] ≈ -2*(Re(fc(x + i*s*e_i)) - f(x)) / s^2
+//
+// s is settings.Step, or complexDiagStep if settings is nil or
+// settings.Step is zero; it is deliberately larger than Central2nd.Step to
+// keep this rounding-sensitive subtraction well clear of cancellation (see
+// complexDiagStep). Every evaluation of fc runs serially in the calling
+// goroutine, so settings.Concurrent has no effect; the other
+// HessianSettings fields do not apply to the complex-step estimate either
+// and are ignored.
+func HessianComplex(dst *mat64.SymDense, fc func([]complex128) complex128, x []float64, settings *HessianSettings) *mat64.SymDense {
+	n := len(x)
+	if dst == nil {
+		dst = mat64.NewSymDense(n, nil)
+	}
+	if dst.Symmetric() != n {
+		panic("hessian: mismatched matrix size")
+	}
+
+	if settings == nil {
+		settings = &HessianSettings{}
+	}
+	s := settings.Step
+	if s == 0 {
+		s = complexDiagStep
+	}
+
+	xc := make([]complex128, n)
+	for i, v := range x {
+		xc[i] = complex(v, 0)
+	}
+
+	eval := func(perturb func(xc []complex128)) complex128 {
+		xcopy := make([]complex128, n)
+		copy(xcopy, xc)
+		perturb(xcopy)
+		return fc(xcopy)
+	}
+
+	origin := real(fc(xc))
+	for i := 0; i < n; i++ {
+		fi := eval(func(xcopy []complex128) {
+			xcopy[i] += complex(0, s)
+		})
+		dst.SetSym(i, i, -2*(real(fi)-origin)/(s*s))
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			fp := eval(func(xcopy []complex128) {
+				xcopy[i] += complex(0, complexStep)
+				xcopy[j] += complex(s, 0)
+			})
+			fm := eval(func(xcopy []complex128) {
+				xcopy[i] += complex(0, complexStep)
+				xcopy[j] -= complex(s, 0)
+			})
+			dst.SetSym(i, j, (imag(fp)-imag(fm))/(2*complexStep*s))
+		}
+	}
+	return dst
+}
+
+// RealValue evaluates fc at the real point x and returns its real part,
+// which equals the original real objective's value there. It is useful for
+// confirming that a complex lift fc agrees with its real counterpart before
+// trusting HessianComplex's derivatives.
+func RealValue(fc func(xc []complex128) complex128, x []float64) float64 {
+	xc := make([]complex128, len(x))
+	for i, v := range x {
+		xc[i] = complex(v, 0)
+	}
+	return real(fc(xc))
+}
@@ -0,0 +1,31 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func saddleComplex(x []complex128) complex128 {
+	return x[0]*x[0] - x[1]*x[1]
+}
+
+func TestHessianComplex(t *testing.T) {
+	x := randomSlice(2, 10)
+	want := mat64.NewSymDense(2, nil)
+	saddleHess(want, x)
+
+	got := HessianComplex(nil, saddleComplex, x, nil)
+	if !mat64.EqualApprox(want, got, 1e-8) {
+		t.Errorf("unexpected Hessian:\nwant: %v\ngot:  %v",
+			mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+	}
+
+	if diff := RealValue(saddleComplex, x) - saddle(x); diff > 1e-12 || diff < -1e-12 {
+		t.Errorf("RealValue mismatch: got %v, want %v", RealValue(saddleComplex, x), saddle(x))
+	}
+}
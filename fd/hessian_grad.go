@@ -0,0 +1,120 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// forwardGradStep is the default step for HessianFromGrad's forward
+// difference of the gradient. Unlike Central2nd or Forward2nd, which
+// difference the objective itself with an O(step^2) or O(step) truncation
+// error tuned against O(eps/step^2) rounding, this differences a first
+// derivative (the gradient) by a forward difference, whose truncation
+// error is only O(step); balancing that against O(eps/step) rounding puts
+// the optimal step at sqrt(eps) rather than Central2nd.Step.
+const forwardGradStep = 1.5e-8
+
+// HessianFromGrad approximates the Hessian of a function at x using its
+// gradient grad(g, x), which stores the gradient of the function at x in g.
+// This matches the Problem.Grad signature used throughout gonum/optimize, so
+// a Newton-type method that already has an analytic or autodiff gradient can
+// reuse it here instead of falling back to Hessian's O(n^2) evaluations of
+// the objective itself.
+//
+// HessianFromGrad forward-differences the gradient along each coordinate
+// axis, costing n+1 gradient evaluations (the evaluation at x plus one per
+// axis), and symmetrizes the result as H = (J + J^T)/2, where J is the
+// matrix of forward differences, using forwardGradStep rather than
+// Central2nd.Step as the default, since differencing a gradient forward is
+// a different stencil with a different optimal step than differencing the
+// objective itself. settings.Step and settings.Concurrent are
+// honored as in Hessian; the other HessianSettings fields do not apply to a
+// gradient-based estimate and are ignored.
+func HessianFromGrad(dst *mat64.SymDense, grad func(g, x []float64), x []float64, settings *HessianSettings) *mat64.SymDense {
+	n := len(x)
+	if dst == nil {
+		dst = mat64.NewSymDense(n, nil)
+	}
+	if dst.Symmetric() != n {
+		panic("hessian: mismatched matrix size")
+	}
+
+	if settings == nil {
+		settings = &HessianSettings{}
+	}
+
+	step := settings.Step
+	if step == 0 {
+		step = forwardGradStep
+	}
+
+	nWorkers := 1
+	if settings.Concurrent {
+		nWorkers = runtime.GOMAXPROCS(0)
+		if nWorkers > n {
+			nWorkers = n
+		}
+	}
+
+	g0 := make([]float64, n)
+	grad(g0, x)
+
+	// jac[i] holds the forward difference (grad(x+step*e_i) - grad(x)) / step,
+	// the i-th column of the (possibly asymmetric) Jacobian of grad.
+	jac := make([][]float64, n)
+	for i := range jac {
+		jac[i] = make([]float64, n)
+	}
+
+	if nWorkers == 1 {
+		xcopy := make([]float64, n)
+		gplus := make([]float64, n)
+		for i := 0; i < n; i++ {
+			copy(xcopy, x)
+			xcopy[i] += step
+			grad(gplus, xcopy)
+
+			for k := 0; k < n; k++ {
+				jac[i][k] = (gplus[k] - g0[k]) / step
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		jobs := make(chan int, nWorkers)
+		for w := 0; w < nWorkers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				xcopy := make([]float64, n)
+				gplus := make([]float64, n)
+				for i := range jobs {
+					copy(xcopy, x)
+					xcopy[i] += step
+					grad(gplus, xcopy)
+
+					for k := 0; k < n; k++ {
+						jac[i][k] = (gplus[k] - g0[k]) / step
+					}
+				}
+			}()
+		}
+		for i := 0; i < n; i++ {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			dst.SetSym(i, j, (jac[i][j]+jac[j][i])/2)
+		}
+	}
+	return dst
+}
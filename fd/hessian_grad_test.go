@@ -0,0 +1,55 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func saddleGrad(g, x []float64) {
+	g[0] = 2 * x[0]
+	g[1] = -2 * x[1]
+}
+
+// monkeySaddleGrad is the gradient of monkeySaddle, unlike saddleGrad
+// nonlinear in x, so a forward difference of it actually has nonzero
+// truncation error and can catch a badly chosen step.
+func monkeySaddleGrad(g, x []float64) {
+	g[0] = 3*x[0]*x[0] - 3*x[1]*x[1]
+	g[1] = -6 * x[0] * x[1]
+}
+
+func TestHessianFromGrad(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		dim  int
+		grad func(g, x []float64)
+		hess func(h *mat64.SymDense, x []float64)
+		tol  float64
+	}{
+		{name: "standard saddle", dim: 2, grad: saddleGrad, hess: saddleHess, tol: 1e-6},
+		{name: "monkey saddle", dim: 2, grad: monkeySaddleGrad, hess: monkeySaddleHess, tol: 1e-5},
+	} {
+		x := randomSlice(test.dim, 10)
+		want := mat64.NewSymDense(test.dim, nil)
+		test.hess(want, x)
+
+		for _, settings := range []struct {
+			name     string
+			settings *HessianSettings
+		}{
+			{name: "default", settings: nil},
+			{name: "concurrent", settings: &HessianSettings{Concurrent: true}},
+		} {
+			got := HessianFromGrad(nil, test.grad, x, settings.settings)
+			if !mat64.EqualApprox(want, got, test.tol) {
+				t.Errorf("%s, %s: unexpected Hessian:\nwant: %v\ngot:  %v",
+					test.name, settings.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"math"
+)
+
+// adaptiveSteps picks a per-axis step size from an estimate of the noise
+// level of f, following the Moré–Wild procedure. For each axis i it samples
+// f at a short sequence of points x + k*delta*e_i, estimates the noise
+// level εf from the third differences of those samples, and an estimate of
+// f'' from the central second difference of the three central samples, then
+// sets the step to (εf / |f''|)^(1/4).
+func adaptiveSteps(f func([]float64) float64, x []float64, origin float64) []float64 {
+	const (
+		nSamples = 7 // k = -3 ... 3
+		delta    = 1e-2
+	)
+	n := len(x)
+	steps := make([]float64, n)
+	xcopy := make([]float64, n)
+	samples := make([]float64, nSamples)
+	for i := range x {
+		h0 := delta * math.Max(1, math.Abs(x[i]))
+		for k := 0; k < nSamples; k++ {
+			copy(xcopy, x)
+			xcopy[i] += float64(k-nSamples/2) * h0
+			samples[k] = f(xcopy)
+		}
+
+		// Third differences estimate the noise: for a smooth function they
+		// are O(h0^3), so their spread is dominated by evaluation noise.
+		var sumSq float64
+		var count int
+		for k := 0; k+3 < nSamples; k++ {
+			d3 := samples[k+3] - 3*samples[k+2] + 3*samples[k+1] - samples[k]
+			sumSq += d3 * d3
+			count++
+		}
+		noise := math.Sqrt(sumSq/float64(count)) / 8 // divide out the O(h0^3) binomial scaling
+
+		mid := nSamples / 2
+		fpp := (samples[mid+1] - 2*samples[mid] + samples[mid-1]) / (h0 * h0)
+		if fpp == 0 {
+			fpp = 1
+		}
+
+		h := math.Pow(noise/math.Abs(fpp), 0.25)
+		if h == 0 || math.IsNaN(h) || math.IsInf(h, 0) {
+			h = Central2nd.Step
+		}
+		steps[i] = h
+	}
+	return steps
+}
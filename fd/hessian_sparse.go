@@ -0,0 +1,291 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// SparseHessianSettings is the settings structure for computing a sparse
+// Hessian.
+type SparseHessianSettings struct {
+	OriginKnown bool
+	OriginValue float64
+	Step        float64
+	Concurrent  bool
+
+	// Coloring, if non-nil, is a precomputed column coloring of the
+	// sparsity pattern as returned by ColorColumns. It may be reused
+	// across repeated calls to SparseHessian with the same pattern, for
+	// example across the iterations of a Newton solver, to avoid
+	// recomputing it every time.
+	Coloring []int
+}
+
+// SymmetrizePattern returns pattern with every entry (i, j) it contains
+// mirrored to also appear as (j, i), so that row i lists every column that
+// touches row i in either direction. pattern need not be symmetric on input;
+// a caller may give only the upper triangle of a symmetric Hessian pattern,
+// as is natural since H is symmetric, and SymmetrizePattern fills in the
+// rest. ColorColumns and SparseHessian both symmetrize their pattern
+// argument internally for this reason, so callers do not usually need to
+// call SymmetrizePattern themselves; it is exported for callers who want to
+// inspect or cache the symmetrized pattern, for example to pass a stable
+// pattern into repeated calls alongside a precomputed Coloring.
+func SymmetrizePattern(pattern [][]int, n int) [][]int {
+	seen := make([]map[int]bool, n)
+	sym := make([][]int, n)
+	for i := range seen {
+		seen[i] = make(map[int]bool)
+	}
+	add := func(i, j int) {
+		if !seen[i][j] {
+			seen[i][j] = true
+			sym[i] = append(sym[i], j)
+		}
+	}
+	for i, row := range pattern {
+		for _, j := range row {
+			add(i, j)
+			add(j, i)
+		}
+	}
+	for i := range sym {
+		sort.Ints(sym[i])
+	}
+	return sym
+}
+
+// ColorColumns computes a greedy distance-2 coloring of the column-adjacency
+// graph induced by pattern: columns i and j (i != j) are adjacent if pattern
+// has an entry (i, j). Columns that share a color are never
+// adjacent and never share a common neighbor, so within any single row at
+// most one pattern entry falls in a given color, and no two colors are ever
+// linked by more than one such row (the "any path of length three uses at
+// least three colors" rule for a symmetric/star coloring). The returned
+// slice has length n and maps each column index to its color, a small
+// non-negative integer.
+//
+// ColorColumns symmetrizes pattern with SymmetrizePattern before building
+// the adjacency graph, so pattern may list each nonzero pair in only one
+// direction.
+//
+// The coloring returned by ColorColumns may be passed back in via
+// SparseHessianSettings.Coloring to avoid recomputing it for a pattern that
+// does not change between calls.
+func ColorColumns(pattern [][]int, n int) []int {
+	pattern = SymmetrizePattern(pattern, n)
+
+	adjacent := make([]map[int]bool, n)
+	for i := range adjacent {
+		adjacent[i] = make(map[int]bool)
+	}
+	for i, row := range pattern {
+		for _, j := range row {
+			if i == j {
+				continue
+			}
+			adjacent[i][j] = true
+			adjacent[j][i] = true
+		}
+	}
+
+	colors := make([]int, n)
+	for i := range colors {
+		forbidden := make(map[int]bool)
+		for k := range adjacent[i] {
+			if k < i {
+				forbidden[colors[k]] = true
+			}
+			// Also forbid the colors of already-colored columns that share
+			// a neighbor with i (distance 2), which is what keeps any path
+			// of length three from using only two colors.
+			for l := range adjacent[k] {
+				if l < i {
+					forbidden[colors[l]] = true
+				}
+			}
+		}
+		c := 0
+		for forbidden[c] {
+			c++
+		}
+		colors[i] = c
+	}
+	return colors
+}
+
+// SparseHessian approximates the Hessian of f at x restricted to the
+// sparsity pattern pattern, where pattern[i] lists the column indices j for
+// which H[i][j] may be nonzero (the diagonal entry i must be included for
+// SparseHessian to fill it in). pattern need not be symmetric; it is passed
+// through SymmetrizePattern before use, so listing (i, j) without also
+// listing (j, i) is enough. Only entries named in pattern (in either
+// direction) are computed; all other entries of dst are left untouched.
+//
+// SparseHessian colors the columns of pattern with ColorColumns (or reuses
+// settings.Coloring, if provided); the coloring guarantees that within any
+// row at most one pattern entry falls in a given color, which is what lets
+// a single grouped evaluation per color (neighColor, below) be shared across
+// every row that needs it, rather than recomputed per row as a dense
+// Hessian would. That still leaves one evaluation per off-diagonal pattern
+// entry to separate it from the rest of its color group's grouped sum, so
+// the total evaluation count is O(nnz(pattern)) rather than Hessian's
+// O(n^2); for a banded or block-structured pattern with bandwidth b,
+// nnz(pattern) is O(b*n), which is the large win for PDE discretizations
+// and separable objectives the coloring is meant for. Getting below
+// O(nnz(pattern)) would need a gradient oracle rather than a scalar f; see
+// HessianFromGrad.
+func SparseHessian(dst *mat64.SymDense, f func([]float64) float64, x []float64, pattern [][]int, settings *SparseHessianSettings) *mat64.SymDense {
+	n := len(x)
+	if dst == nil {
+		dst = mat64.NewSymDense(n, nil)
+	}
+	if dst.Symmetric() != n {
+		panic("hessian: mismatched matrix size")
+	}
+	if len(pattern) != n {
+		panic("fd: pattern length mismatch")
+	}
+
+	if settings == nil {
+		settings = &SparseHessianSettings{}
+	}
+
+	step := settings.Step
+	if step == 0 {
+		step = Central2nd.Step
+	}
+
+	// Symmetrize once so that both the coloring and the entries enumerated
+	// below see every row's full, symmetric neighbor set, regardless of
+	// whether the caller listed a pattern pair in one direction or both.
+	pattern = SymmetrizePattern(pattern, n)
+
+	colors := settings.Coloring
+	if colors == nil {
+		colors = ColorColumns(pattern, n)
+	}
+	nColors := 0
+	for _, c := range colors {
+		if c+1 > nColors {
+			nColors = c + 1
+		}
+	}
+	colorCols := make([][]int, nColors)
+	for j, c := range colors {
+		colorCols[c] = append(colorCols[c], j)
+	}
+
+	xcopy := make([]float64, n)
+	origin := settings.OriginValue
+	if !settings.OriginKnown {
+		copy(xcopy, x)
+		origin = f(xcopy)
+	}
+
+	nWorkers := 1
+	if settings.Concurrent {
+		nWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	neigh := evalConcurrent(x, n, nWorkers, func(xcopy []float64, i int) {
+		copy(xcopy, x)
+		xcopy[i] += step
+	}, f)
+
+	neighColor := evalConcurrent(x, nColors, nWorkers, func(xcopy []float64, c int) {
+		copy(xcopy, x)
+		for _, j := range colorCols[c] {
+			xcopy[j] += step
+		}
+	}, f)
+
+	type entry struct{ i, j int }
+	var entries []entry
+	for i, row := range pattern {
+		for _, j := range row {
+			if j < i {
+				continue // already covered from row j's side; H is symmetric.
+			}
+			entries = append(entries, entry{i, j})
+		}
+	}
+	results := evalConcurrentIdx(n, len(entries), nWorkers, func(xcopy []float64, k int) float64 {
+		e := entries[k]
+		copy(xcopy, x)
+		if e.i == e.j {
+			xcopy[e.i] -= step
+			return f(xcopy)
+		}
+		xcopy[e.i] += step
+		for _, j := range colorCols[colors[e.j]] {
+			xcopy[j] += step
+		}
+		return f(xcopy)
+	})
+	for k, e := range entries {
+		if e.i == e.j {
+			dst.SetSym(e.i, e.i, (neigh[e.i]-2*origin+results[k])/(step*step))
+			continue
+		}
+		c := colors[e.j]
+		dst.SetSym(e.i, e.j, (results[k]-neigh[e.i]-neighColor[c]+origin)/(step*step))
+	}
+	return dst
+}
+
+// evalConcurrent evaluates f at n perturbations of x, where perturb fills
+// a scratch copy of x with the i-th perturbed point, spreading the work
+// over nWorkers goroutines, each with its own scratch slice of length
+// len(x).
+func evalConcurrent(x []float64, n, nWorkers int, perturb func(xcopy []float64, i int), f func([]float64) float64) []float64 {
+	return evalConcurrentIdx(len(x), n, nWorkers, func(xcopy []float64, i int) float64 {
+		perturb(xcopy, i)
+		return f(xcopy)
+	})
+}
+
+// evalConcurrentIdx calls eval(xcopy, i) for i in [0, n) across nWorkers
+// goroutines, each with its own scratch slice of length dim, and collects
+// the results in index order.
+func evalConcurrentIdx(dim, n, nWorkers int, eval func(xcopy []float64, i int) float64) []float64 {
+	out := make([]float64, n)
+	if n == 0 {
+		return out
+	}
+	if nWorkers > n {
+		nWorkers = n
+	}
+	if nWorkers <= 1 {
+		xcopy := make([]float64, dim)
+		for i := 0; i < n; i++ {
+			out[i] = eval(xcopy, i)
+		}
+		return out
+	}
+	var wg sync.WaitGroup
+	jobs := make(chan int, nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			xcopy := make([]float64, dim)
+			for i := range jobs {
+				out[i] = eval(xcopy, i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return out
+}
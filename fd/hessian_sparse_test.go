@@ -0,0 +1,104 @@
+// Copyright ©2016 The gonum Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fd
+
+import (
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// tridiagonal returns a function whose Hessian is tridiagonal:
+// f(x) = sum_i x_i^2 + sum_i x_i*x_{i+1}.
+func tridiagonal(x []float64) float64 {
+	var sum float64
+	for i, v := range x {
+		sum += v * v
+		if i+1 < len(x) {
+			sum += v * x[i+1]
+		}
+	}
+	return sum
+}
+
+func tridiagonalPattern(n int) [][]int {
+	pattern := make([][]int, n)
+	for i := range pattern {
+		pattern[i] = append(pattern[i], i)
+		if i+1 < n {
+			pattern[i] = append(pattern[i], i+1)
+		}
+	}
+	return pattern
+}
+
+func TestSparseHessian(t *testing.T) {
+	const n = 6
+	x := randomSlice(n, 10)
+	pattern := tridiagonalPattern(n)
+
+	want := mat64.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		want.SetSym(i, i, 2)
+		if i+1 < n {
+			want.SetSym(i, i+1, 1)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		settings *SparseHessianSettings
+	}{
+		{name: "default", settings: nil},
+		{name: "known origin", settings: &SparseHessianSettings{OriginKnown: true, OriginValue: tridiagonal(x)}},
+		{name: "concurrent", settings: &SparseHessianSettings{Concurrent: true}},
+		{name: "precomputed coloring", settings: &SparseHessianSettings{Coloring: ColorColumns(pattern, n)}},
+	} {
+		got := SparseHessian(nil, tridiagonal, x, pattern, test.settings)
+		for i := 0; i < n; i++ {
+			for _, j := range pattern[i] {
+				if diff := got.At(i, j) - want.At(i, j); diff > 1e-4 || diff < -1e-4 {
+					t.Errorf("%s: H[%d,%d] = %v, want %v", test.name, i, j, got.At(i, j), want.At(i, j))
+				}
+			}
+		}
+	}
+}
+
+func TestSymmetrizePattern(t *testing.T) {
+	// tridiagonalPattern is upper-triangular only: row i lists i and i+1,
+	// but row i+1 does not list i back. SymmetrizePattern must add it.
+	pattern := SymmetrizePattern(tridiagonalPattern(6), 6)
+	for i, row := range pattern {
+		has := make(map[int]bool)
+		for _, j := range row {
+			has[j] = true
+		}
+		if i > 0 && !has[i-1] {
+			t.Errorf("row %d is missing symmetric entry for column %d", i, i-1)
+		}
+		if i+1 < 6 && !has[i+1] {
+			t.Errorf("row %d is missing entry for column %d", i, i+1)
+		}
+	}
+}
+
+func TestColorColumns(t *testing.T) {
+	pattern := tridiagonalPattern(6)
+	colors := ColorColumns(pattern, 6)
+	for i, row := range pattern {
+		seen := make(map[int]bool)
+		for _, j := range row {
+			if i == j {
+				continue
+			}
+			c := colors[j]
+			if seen[c] {
+				t.Errorf("row %d has two pattern columns sharing color %d", i, c)
+			}
+			seen[c] = true
+		}
+	}
+}
@@ -41,27 +41,36 @@ func TestHessian(t *testing.T) {
 		f    func([]float64) float64
 		hess func(h *mat64.SymDense, x []float64)
 		tol  float64
+		// fwdTol is the tolerance for the Forward2nd subtest, which can be
+		// looser than tol: Forward2nd is only first-order accurate, so
+		// unlike Central2nd, whose diagonal stencil is exact for a cubic,
+		// its truncation error does not vanish for a cubic such as
+		// monkeySaddle.
+		fwdTol float64
 	}{
 		{
-			name: "quadratic",
-			dim:  1,
-			f:    quadratic,
-			hess: quadraticHess,
-			tol:  1e-6,
+			name:   "quadratic",
+			dim:    1,
+			f:      quadratic,
+			hess:   quadraticHess,
+			tol:    1e-6,
+			fwdTol: 1e-6,
 		},
 		{
-			name: "standard saddle",
-			dim:  2,
-			f:    saddle,
-			hess: saddleHess,
-			tol:  1e-6,
+			name:   "standard saddle",
+			dim:    2,
+			f:      saddle,
+			hess:   saddleHess,
+			tol:    1e-6,
+			fwdTol: 1e-6,
 		},
 		{
-			name: "monkey saddle",
-			dim:  2,
-			f:    monkeySaddle,
-			hess: monkeySaddleHess,
-			tol:  1e-4,
+			name:   "monkey saddle",
+			dim:    2,
+			f:      monkeySaddle,
+			hess:   monkeySaddleHess,
+			tol:    1e-4,
+			fwdTol: 1e-2,
 		},
 	} {
 		for k := 0; k < 10; k++ {
@@ -110,6 +119,33 @@ func TestHessian(t *testing.T) {
 				t.Errorf("Case %d (%s, known origin, concurrent): unexpected Hessian:\nwant: %v\ngot:  %v",
 					i, test.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
 			}
+
+			fillNaNSym(got)
+			Hessian(got, test.f, x, &HessianSettings{
+				Extrapolate: true,
+			})
+			if !mat64.EqualApprox(want, got, test.tol) {
+				t.Errorf("Case %d (%s, extrapolate): unexpected Hessian:\nwant: %v\ngot:  %v",
+					i, test.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+			}
+
+			fillNaNSym(got)
+			Hessian(got, test.f, x, &HessianSettings{
+				Adaptive: true,
+			})
+			if !mat64.EqualApprox(want, got, test.tol) {
+				t.Errorf("Case %d (%s, adaptive): unexpected Hessian:\nwant: %v\ngot:  %v",
+					i, test.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+			}
+
+			fillNaNSym(got)
+			Hessian(got, test.f, x, &HessianSettings{
+				Formula: Forward2nd,
+			})
+			if !mat64.EqualApprox(want, got, test.fwdTol) {
+				t.Errorf("Case %d (%s, Forward2nd): unexpected Hessian:\nwant: %v\ngot:  %v",
+					i, test.name, mat64.Formatted(want, mat64.Prefix("      ")), mat64.Formatted(got, mat64.Prefix("      ")))
+			}
 		}
 	}
 }